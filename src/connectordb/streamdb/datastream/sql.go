@@ -1,12 +1,21 @@
 package datastream
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 /*
-The datastream table:
+The datastream table (Postgres flavor - see sqliteSchema/mysqlSchema for the other backends; the
+UNIQUE and PRIMARY KEY constraints that postgresSchema creates lead with Namespace, i.e.
+(Namespace, StreamId, Substream, EndIndex)):
 
 CREATE TABLE IF NOT EXISTS datastream (
     StreamId BIGINT NOT NULL,
@@ -15,9 +24,33 @@ CREATE TABLE IF NOT EXISTS datastream (
     EndIndex BIGINT,
 	Version INTEGER,
     Data BYTEA,
+    Expiry TIMESTAMP,
+    Namespace VARCHAR NOT NULL DEFAULT '',
     UNIQUE (StreamId, Substream, EndIndex),
     PRIMARY KEY (StreamId, Substream, EndIndex)
     );
+
+Namespace scopes every row to a tenant (e.g. a device or user). An SqlStore opened with a
+namespace only ever sees and mutates rows carrying that namespace, so several tenants can share
+one datastream table. The one exception is DeleteNamespace, which deletes the rows of whichever
+namespace it is given.
+
+A separate streamendindex table tracks, per (StreamId, Substream, Namespace), the highest EndIndex
+ever assigned:
+
+CREATE TABLE IF NOT EXISTS streamendindex (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR NOT NULL,
+    Namespace VARCHAR NOT NULL DEFAULT '',
+    EndIndex BIGINT NOT NULL,
+    PRIMARY KEY (StreamId, Substream, Namespace)
+    );
+
+GetEndIndex reads this table rather than taking MAX(EndIndex) over datastream directly, because
+Reap physically deletes expired rows: if EndIndex were derived from the rows still present, an
+expired row at the tail of a stream being reaped would make GetEndIndex fall back to an earlier,
+already-assigned index, and the next Insert/Append would silently reuse it for different data.
+streamendindex only ever moves forward, independent of which datastream rows still exist.
 */
 
 var (
@@ -25,20 +58,77 @@ var (
 	ErrorDatabaseCorrupted = errors.New("Database is corrupted!")
 	//ErrWTF is returned when an internal assertion fails - it shoudl not happen. Ever.
 	ErrWTF = errors.New("Something is seriously wrong. A internal assertion failed.")
+	//ErrUnknownDriver is returned when OpenSqlStore is asked to open a SqlDriver it doesn't know how to handle
+	ErrUnknownDriver = errors.New("Unrecognized SQL driver")
+	//ErrStoreClosed is returned by Insert/Append/WriteBatches/DeleteStream/DeleteSubstream when called after Close.
+	ErrStoreClosed = errors.New("datastream: store is closed")
+)
+
+//SqlDriver identifies which SQL backend an SqlStore talks to, so that dialect-specific
+//behavior (placeholder syntax, column types, upsert semantics) can be chosen at open time.
+type SqlDriver uint8
+
+const (
+	//DriverPostgres is the original, most heavily tested backend.
+	DriverPostgres SqlDriver = iota
+	//DriverSQLite backs an SqlStore with a local sqlite3 database file.
+	DriverSQLite
+	//DriverMySQL backs an SqlStore with a MySQL/MariaDB database.
+	DriverMySQL
 )
 
+//DefaultMaxBatchRows bounds how many rows WriteBatches groups into a single multi-row INSERT
+//statement. Postgres rejects more than 65535 bind parameters per statement, and each datastream
+//row binds 8, so this stays comfortably under that with room to spare for other backends.
+const DefaultMaxBatchRows = 1000
+
 //The SqlStore stores and queries arrays of Datapoints in an SQL database. The table 'datastream' is assumed
-//to already exist and the correct indices are assumed to already exist.
+//to already exist and the correct indices are assumed to already exist (see CreateSchema to set one up).
 type SqlStore struct {
-	inserter     *sql.Stmt
-	timequery    *sql.Stmt
-	indexquery   *sql.Stmt
-	endindex     *sql.Stmt
-	delsubstream *sql.Stmt
-	delstream    *sql.Stmt
-	clearall     *sql.Stmt
+	driver SqlDriver
+	db     *sql.DB
+
+	//namespace scopes every statement this store prepares to rows carrying this value in the
+	//Namespace column. The default store (namespace "") sees only rows whose Namespace is "".
+	namespace string
+
+	inserter        *sql.Stmt
+	timequery       *sql.Stmt
+	indexquery      *sql.Stmt
+	timerangequery  *sql.Stmt
+	indexrangequery *sql.Stmt
+	endindex        *sql.Stmt
+	bumpendindex    *sql.Stmt
+	delsubstream    *sql.Stmt
+	delstream       *sql.Stmt
+	clearall        *sql.Stmt
+	reaper          *sql.Stmt
+
+	//delsubstreamendindex/delstreamendindex/clearallendindex remove the matching streamendindex
+	//rows alongside delsubstream/delstream/clearall, so a deleted stream/substream/namespace's
+	//high-water mark can't outlive the rows it described (see streamendindex in the package doc).
+	delsubstreamendindex *sql.Stmt
+	delstreamendindex    *sql.Stmt
+	clearallendindex     *sql.Stmt
 
 	insertversion int
+
+	//maxBatchRows bounds how many rows WriteBatches groups into one INSERT statement.
+	maxBatchRows int
+	//useCopy enables the Postgres-only COPY FROM STDIN fast path for WriteBatches.
+	useCopy bool
+
+	//writersMu guards writers and closed.
+	writersMu sync.Mutex
+	//writers holds one Writer per stream that has been written to, keyed by streamID, so that
+	//all mutations to a given stream are serialized through a single goroutine.
+	writers map[int64]*streamWriter
+	//closed is set by Close, so that a dispatch arriving after Close starts fails with
+	//ErrStoreClosed instead of spawning a new Writer that will never be shut down.
+	closed bool
+	//flushWindow bounds how long a Writer waits for more consecutive Appends to the same
+	//substream to arrive before committing the ones it already has.
+	flushWindow time.Duration
 }
 
 //This function is to allow daisy-chaining errors from statement creation
@@ -50,8 +140,10 @@ func prepStatement(db *sql.DB, statement string, err error) (*sql.Stmt, error) {
 }
 
 //prepareSqlStore sets up the inserts (it assumes that the database was already prepared)
-func prepareSqlStore(db *sql.DB, insertStatement, timequeryStatement, indexqueryStatement,
-	endindexStatement, delsubstreamStatement, delstreamStatement, clearallStatement string) (*SqlStore, error) {
+func prepareSqlStore(db *sql.DB, driver SqlDriver, namespace string, insertStatement, timequeryStatement, indexqueryStatement,
+	timerangequeryStatement, indexrangequeryStatement,
+	endindexStatement, bumpendindexStatement, delsubstreamStatement, delstreamStatement, clearallStatement, reapStatement string,
+	delsubstreamendindexStatement, delstreamendindexStatement, clearallendindexStatement string) (*SqlStore, error) {
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
@@ -59,12 +151,41 @@ func prepareSqlStore(db *sql.DB, insertStatement, timequeryStatement, indexquery
 	inserter, err := prepStatement(db, insertStatement, nil)
 	timequery, err := prepStatement(db, timequeryStatement, err)
 	indexquery, err := prepStatement(db, indexqueryStatement, err)
+	timerangequery, err := prepStatement(db, timerangequeryStatement, err)
+	indexrangequery, err := prepStatement(db, indexrangequeryStatement, err)
 	endindex, err := prepStatement(db, endindexStatement, err)
+	bumpendindex, err := prepStatement(db, bumpendindexStatement, err)
 	delsubstream, err := prepStatement(db, delsubstreamStatement, err)
 	delstream, err := prepStatement(db, delstreamStatement, err)
 	clearall, err := prepStatement(db, clearallStatement, err)
+	reaper, err := prepStatement(db, reapStatement, err)
+	delsubstreamendindex, err := prepStatement(db, delsubstreamendindexStatement, err)
+	delstreamendindex, err := prepStatement(db, delstreamendindexStatement, err)
+	clearallendindex, err := prepStatement(db, clearallendindexStatement, err)
 
-	ss := &SqlStore{inserter, timequery, indexquery, endindex, delsubstream, delstream, clearall, 2}
+	ss := &SqlStore{
+		driver:               driver,
+		db:                   db,
+		namespace:            namespace,
+		inserter:             inserter,
+		timequery:            timequery,
+		indexquery:           indexquery,
+		timerangequery:       timerangequery,
+		indexrangequery:      indexrangequery,
+		endindex:             endindex,
+		bumpendindex:         bumpendindex,
+		delsubstream:         delsubstream,
+		delstream:            delstream,
+		clearall:             clearall,
+		reaper:               reaper,
+		delsubstreamendindex: delsubstreamendindex,
+		delstreamendindex:    delstreamendindex,
+		clearallendindex:     clearallendindex,
+		insertversion:        2,
+		maxBatchRows:         DefaultMaxBatchRows,
+		writers:              make(map[int64]*streamWriter),
+		flushWindow:          DefaultWriterFlushWindow,
+	}
 
 	if err != nil {
 		ss.Close()
@@ -74,24 +195,232 @@ func prepareSqlStore(db *sql.DB, insertStatement, timequeryStatement, indexquery
 	return ss, nil
 }
 
-//OpenPostgresStore initializes a postgres database to work with an SqlStore.
+//OpenPostgresStore initializes a postgres database to work with an SqlStore scoped to the
+//default (empty) namespace.
 func OpenPostgresStore(db *sql.DB) (*SqlStore, error) {
-	return prepareSqlStore(db, "INSERT INTO datastream VALUES ($1,$2,$3,$4,$5,$6);",
-		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND EndTime > $3 ORDER BY EndTime ASC;",
-		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND EndIndex > $3 ORDER BY EndIndex ASC;",
-		"SELECT COALESCE(MAX(EndIndex),0) FROM datastream WHERE StreamID=$1 AND Substream=$2;",
-		"DELETE FROM datastream WHERE StreamID=$1 AND Substream=$2;",
-		"DELETE FROM datastream WHERE StreamID=$1;",
-		"DELETE FROM datastream;")
+	return OpenPostgresStoreNamespaced(db, "")
+}
+
+//OpenPostgresStoreNamespaced is OpenPostgresStore, but the returned SqlStore only sees and
+//mutates rows whose Namespace column equals ns, so several tenants (e.g. devices or users) can
+//share one datastream table without their data or EndIndex sequences colliding. Use
+//DeleteNamespace to tear a tenant's rows down.
+func OpenPostgresStoreNamespaced(db *sql.DB, ns string) (*SqlStore, error) {
+	return prepareSqlStore(db, DriverPostgres, ns, "INSERT INTO datastream VALUES ($1,$2,$3,$4,$5,$6,$7,$8);",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND Namespace=$3 AND EndTime > $4 AND (Expiry IS NULL OR Expiry > now()) ORDER BY EndTime ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND Namespace=$3 AND EndIndex > $4 AND (Expiry IS NULL OR Expiry > now()) ORDER BY EndIndex ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND Namespace=$3 AND EndTime > $4 AND EndTime <= $5 AND (Expiry IS NULL OR Expiry > now()) ORDER BY EndTime ASC LIMIT $6;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=$1 AND Substream=$2 AND Namespace=$3 AND EndIndex > $4 AND EndIndex <= $5 AND (Expiry IS NULL OR Expiry > now()) ORDER BY EndIndex ASC LIMIT $6;",
+		"SELECT COALESCE((SELECT EndIndex FROM streamendindex WHERE StreamId=$1 AND Substream=$2 AND Namespace=$3),0);",
+		"INSERT INTO streamendindex (StreamId,Substream,Namespace,EndIndex) VALUES ($1,$2,$3,$4) ON CONFLICT (StreamId,Substream,Namespace) DO UPDATE SET EndIndex=EXCLUDED.EndIndex WHERE streamendindex.EndIndex < EXCLUDED.EndIndex;",
+		"DELETE FROM datastream WHERE StreamID=$1 AND Substream=$2 AND Namespace=$3;",
+		"DELETE FROM datastream WHERE StreamID=$1 AND Namespace=$2;",
+		"DELETE FROM datastream WHERE Namespace=$1;",
+		"DELETE FROM datastream WHERE Namespace=$1 AND Expiry IS NOT NULL AND Expiry < now();",
+		"DELETE FROM streamendindex WHERE StreamId=$1 AND Substream=$2 AND Namespace=$3;",
+		"DELETE FROM streamendindex WHERE StreamId=$1 AND Namespace=$2;",
+		"DELETE FROM streamendindex WHERE Namespace=$1;")
 }
 
-//OpenSqlStore uses the correct initializer for the given database driver
-func OpenSqlStore(db *sql.DB) (*SqlStore, error) {
-	return OpenPostgresStore(db)
+//OpenSQLiteStore initializes a sqlite3 database to work with an SqlStore. Placeholders use the
+//"?" syntax sqlite expects rather than Postgres's "$n".
+func OpenSQLiteStore(db *sql.DB) (*SqlStore, error) {
+	return OpenSQLiteStoreNamespaced(db, "")
+}
+
+//OpenSQLiteStoreNamespaced is OpenSQLiteStore, but the returned SqlStore only sees and mutates
+//rows whose Namespace column equals ns, so several tenants (e.g. devices or users) can share one
+//datastream table without their data or EndIndex sequences colliding. Use DeleteNamespace to tear
+//a tenant's rows down.
+func OpenSQLiteStoreNamespaced(db *sql.DB, ns string) (*SqlStore, error) {
+	return prepareSqlStore(db, DriverSQLite, ns, "INSERT INTO datastream VALUES (?,?,?,?,?,?,?,?);",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndTime > ? AND (Expiry IS NULL OR julianday(Expiry) > julianday('now')) ORDER BY EndTime ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndIndex > ? AND (Expiry IS NULL OR julianday(Expiry) > julianday('now')) ORDER BY EndIndex ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndTime > ? AND EndTime <= ? AND (Expiry IS NULL OR julianday(Expiry) > julianday('now')) ORDER BY EndTime ASC LIMIT ?;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndIndex > ? AND EndIndex <= ? AND (Expiry IS NULL OR julianday(Expiry) > julianday('now')) ORDER BY EndIndex ASC LIMIT ?;",
+		"SELECT COALESCE((SELECT EndIndex FROM streamendindex WHERE StreamId=? AND Substream=? AND Namespace=?),0);",
+		"INSERT INTO streamendindex (StreamId,Substream,Namespace,EndIndex) VALUES (?,?,?,?) ON CONFLICT(StreamId,Substream,Namespace) DO UPDATE SET EndIndex=excluded.EndIndex WHERE excluded.EndIndex > streamendindex.EndIndex;",
+		"DELETE FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=?;",
+		"DELETE FROM datastream WHERE StreamID=? AND Namespace=?;",
+		"DELETE FROM datastream WHERE Namespace=?;",
+		"DELETE FROM datastream WHERE Namespace=? AND Expiry IS NOT NULL AND julianday(Expiry) < julianday('now');",
+		"DELETE FROM streamendindex WHERE StreamId=? AND Substream=? AND Namespace=?;",
+		"DELETE FROM streamendindex WHERE StreamId=? AND Namespace=?;",
+		"DELETE FROM streamendindex WHERE Namespace=?;")
+}
+
+//OpenMySQLStore initializes a MySQL/MariaDB database to work with an SqlStore. Placeholders use the
+//"?" syntax MySQL expects rather than Postgres's "$n".
+func OpenMySQLStore(db *sql.DB) (*SqlStore, error) {
+	return OpenMySQLStoreNamespaced(db, "")
+}
+
+//OpenMySQLStoreNamespaced is OpenMySQLStore, but the returned SqlStore only sees and mutates rows
+//whose Namespace column equals ns, so several tenants (e.g. devices or users) can share one
+//datastream table without their data or EndIndex sequences colliding. Use DeleteNamespace to tear
+//a tenant's rows down.
+func OpenMySQLStoreNamespaced(db *sql.DB, ns string) (*SqlStore, error) {
+	return prepareSqlStore(db, DriverMySQL, ns, "INSERT INTO datastream VALUES (?,?,?,?,?,?,?,?);",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndTime > ? AND (Expiry IS NULL OR Expiry > NOW()) ORDER BY EndTime ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndIndex > ? AND (Expiry IS NULL OR Expiry > NOW()) ORDER BY EndIndex ASC;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndTime > ? AND EndTime <= ? AND (Expiry IS NULL OR Expiry > NOW()) ORDER BY EndTime ASC LIMIT ?;",
+		"SELECT Version,EndIndex,Data FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=? AND EndIndex > ? AND EndIndex <= ? AND (Expiry IS NULL OR Expiry > NOW()) ORDER BY EndIndex ASC LIMIT ?;",
+		"SELECT COALESCE((SELECT EndIndex FROM streamendindex WHERE StreamId=? AND Substream=? AND Namespace=?),0);",
+		"INSERT INTO streamendindex (StreamId,Substream,Namespace,EndIndex) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE EndIndex = GREATEST(EndIndex, VALUES(EndIndex));",
+		"DELETE FROM datastream WHERE StreamID=? AND Substream=? AND Namespace=?;",
+		"DELETE FROM datastream WHERE StreamID=? AND Namespace=?;",
+		"DELETE FROM datastream WHERE Namespace=?;",
+		"DELETE FROM datastream WHERE Namespace=? AND Expiry IS NOT NULL AND Expiry < NOW();",
+		"DELETE FROM streamendindex WHERE StreamId=? AND Substream=? AND Namespace=?;",
+		"DELETE FROM streamendindex WHERE StreamId=? AND Namespace=?;",
+		"DELETE FROM streamendindex WHERE Namespace=?;")
+}
+
+//OpenSqlStore uses the correct initializer for the given SqlDriver
+func OpenSqlStore(db *sql.DB, driver SqlDriver) (*SqlStore, error) {
+	switch driver {
+	case DriverPostgres:
+		return OpenPostgresStore(db)
+	case DriverSQLite:
+		return OpenSQLiteStore(db)
+	case DriverMySQL:
+		return OpenMySQLStore(db)
+	}
+	return nil, ErrUnknownDriver
+}
+
+//postgresSchema creates the datastream table on a fresh Postgres database.
+const postgresSchema = `CREATE TABLE IF NOT EXISTS datastream (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR,
+    EndTime DOUBLE PRECISION,
+    EndIndex BIGINT,
+    Version INTEGER,
+    Data BYTEA,
+    Expiry TIMESTAMP,
+    Namespace VARCHAR NOT NULL DEFAULT '',
+    UNIQUE (Namespace, StreamId, Substream, EndIndex),
+    PRIMARY KEY (Namespace, StreamId, Substream, EndIndex)
+    );
+CREATE INDEX IF NOT EXISTS datastream_namespace_idx ON datastream (Namespace, StreamId, Substream, EndIndex);
+CREATE TABLE IF NOT EXISTS streamendindex (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR NOT NULL,
+    Namespace VARCHAR NOT NULL DEFAULT '',
+    EndIndex BIGINT NOT NULL,
+    PRIMARY KEY (StreamId, Substream, Namespace)
+    );`
+
+//sqliteSchema creates the datastream table on a fresh sqlite3 database file.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS datastream (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR,
+    EndTime DOUBLE PRECISION,
+    EndIndex BIGINT,
+    Version INTEGER,
+    Data BLOB,
+    Expiry TIMESTAMP,
+    Namespace VARCHAR NOT NULL DEFAULT '',
+    UNIQUE (Namespace, StreamId, Substream, EndIndex),
+    PRIMARY KEY (Namespace, StreamId, Substream, EndIndex)
+    );
+CREATE INDEX IF NOT EXISTS datastream_namespace_idx ON datastream (Namespace, StreamId, Substream, EndIndex);
+CREATE TABLE IF NOT EXISTS streamendindex (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR NOT NULL,
+    Namespace VARCHAR NOT NULL DEFAULT '',
+    EndIndex BIGINT NOT NULL,
+    PRIMARY KEY (StreamId, Substream, Namespace)
+    );`
+
+//mysqlSchema creates the datastream table on a fresh MySQL/MariaDB database. MySQL needs a length
+//on every VARCHAR, so Substream and Namespace are VARCHAR(255); Data is a LONGBLOB, which no key or
+//index includes. MySQL also has no CREATE INDEX IF NOT EXISTS, so a
+//second CreateSchema call on an already-initialized database will fail creating the index again.
+const mysqlSchema = `CREATE TABLE IF NOT EXISTS datastream (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR(255),
+    EndTime DOUBLE PRECISION,
+    EndIndex BIGINT,
+    Version INTEGER,
+    Data LONGBLOB,
+    Expiry TIMESTAMP NULL,
+    Namespace VARCHAR(255) NOT NULL DEFAULT '',
+    UNIQUE (Namespace, StreamId, Substream, EndIndex),
+    PRIMARY KEY (Namespace, StreamId, Substream, EndIndex)
+    );
+CREATE INDEX datastream_namespace_idx ON datastream (Namespace, StreamId, Substream, EndIndex);
+CREATE TABLE IF NOT EXISTS streamendindex (
+    StreamId BIGINT NOT NULL,
+    Substream VARCHAR(255) NOT NULL,
+    Namespace VARCHAR(255) NOT NULL DEFAULT '',
+    EndIndex BIGINT NOT NULL,
+    PRIMARY KEY (StreamId, Substream, Namespace)
+    );`
+
+//CreateSchema initializes the datastream table (and its indices) on a fresh database for the
+//given driver, so that a new SQLite file or MySQL database can be bootstrapped without reaching
+//for an external tool.
+func CreateSchema(db *sql.DB, driver SqlDriver) error {
+	switch driver {
+	case DriverPostgres:
+		_, err := db.Exec(postgresSchema)
+		return err
+	case DriverSQLite:
+		_, err := db.Exec(sqliteSchema)
+		return err
+	case DriverMySQL:
+		_, err := db.Exec(mysqlSchema)
+		return err
+	}
+	return ErrUnknownDriver
+}
+
+//String gives the driver's name, matching the config value used to select it.
+func (d SqlDriver) String() string {
+	switch d {
+	case DriverPostgres:
+		return "postgres"
+	case DriverSQLite:
+		return "sqlite"
+	case DriverMySQL:
+		return "mysql"
+	}
+	return fmt.Sprintf("SqlDriver(%d)", uint8(d))
+}
+
+//ParseSqlDriver turns a config/flag string ("postgres", "sqlite", "mysql") into a SqlDriver.
+func ParseSqlDriver(name string) (SqlDriver, error) {
+	switch name {
+	case "postgres", "postgresql":
+		return DriverPostgres, nil
+	case "sqlite", "sqlite3":
+		return DriverSQLite, nil
+	case "mysql":
+		return DriverMySQL, nil
+	}
+	return DriverPostgres, ErrUnknownDriver
 }
 
 //Close all resources associated with the SqlStore.
 func (s *SqlStore) Close() {
+	s.writersMu.Lock()
+	s.closed = true
+	writers := s.writers
+	s.writers = make(map[int64]*streamWriter)
+	s.writersMu.Unlock()
+
+	//Each writer's own mu is taken (rather than closing requests while holding writersMu) so
+	//that a dispatch which already fetched w before this Close runs can't send on requests after
+	//it's been closed: dispatch and this loop both serialize the closed-check/send and the
+	//closed-set/close through the same per-writer lock.
+	for _, w := range writers {
+		w.mu.Lock()
+		w.closed = true
+		close(w.requests)
+		w.mu.Unlock()
+	}
+
 	//The if statements allow to close a partially initialized store
 	if s.inserter != nil {
 		s.inserter.Close()
@@ -102,28 +431,99 @@ func (s *SqlStore) Close() {
 	if s.indexquery != nil {
 		s.indexquery.Close()
 	}
+	if s.timerangequery != nil {
+		s.timerangequery.Close()
+	}
+	if s.indexrangequery != nil {
+		s.indexrangequery.Close()
+	}
 	if s.endindex != nil {
 		s.endindex.Close()
 	}
+	if s.bumpendindex != nil {
+		s.bumpendindex.Close()
+	}
 	if s.delstream != nil {
 		s.delstream.Close()
 	}
 	if s.delsubstream != nil {
 		s.delsubstream.Close()
 	}
+	if s.clearall != nil {
+		s.clearall.Close()
+	}
+	if s.reaper != nil {
+		s.reaper.Close()
+	}
+	if s.delstreamendindex != nil {
+		s.delstreamendindex.Close()
+	}
+	if s.delsubstreamendindex != nil {
+		s.delsubstreamendindex.Close()
+	}
+	if s.clearallendindex != nil {
+		s.clearallendindex.Close()
+	}
 }
 
-//Clear the entire table of all data
+//Clear deletes all data in this store's namespace. On a default (non-namespaced) store, that's
+//the rows whose Namespace is ""; rows of other namespaces are left in place. Use DeleteNamespace
+//instead if you mean to tear down a different tenant's data.
 func (s *SqlStore) Clear() error {
-	_, err := s.clearall.Exec()
-	return err
+	return s.deleteNamespaceTx(s.namespace)
+}
+
+//DeleteNamespace deletes all data belonging to the given namespace, regardless of which
+//namespace this SqlStore itself was opened with. It's meant for tenant teardown (e.g. when a
+//device or user is deleted), so it runs directly rather than through the per-stream Writer: it
+//isn't scoped to a single streamID.
+func (s *SqlStore) DeleteNamespace(ns string) error {
+	return s.deleteNamespaceTx(ns)
+}
+
+//deleteNamespaceTx deletes every datastream row for ns along with its streamendindex rows, in one
+//transaction, so a reprovisioned namespace (e.g. a reused device/user ID) never inherits a prior
+//tenant's EndIndex high-water marks.
+func (s *SqlStore) deleteNamespaceTx(ns string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Stmt(s.clearall).Exec(ns); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Stmt(s.clearallendindex).Exec(ns); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//Reap launches a goroutine that, every interval, deletes rows whose Expiry has passed, and runs
+//until ctx is canceled. This mirrors the expiry model used by SQL-backed key/value stores: rows
+//carry an optional Expiry (see InsertTTL/AppendTTL), and a caller-driven cleanup loop evicts them,
+//giving callers a retention policy without a custom cleanup job.
+func (s *SqlStore) Reap(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.reaper.Exec(s.namespace)
+			}
+		}
+	}()
 }
 
 //GetEndIndex returns the first index point outside of the most recent datapointarray stored within the database.
 //In effect, if the datapoints in a key were all in one huge array, returns array.length
 //(not including the datapoints which are not yet committed to the SqlStore)
 func (s *SqlStore) GetEndIndex(streamID int64, substream string) (ei int64, err error) {
-	rows, err := s.endindex.Query(streamID, substream)
+	rows, err := s.endindex.Query(streamID, substream, s.namespace)
 	if err != nil {
 		return 0, err
 	}
@@ -136,55 +536,363 @@ func (s *SqlStore) GetEndIndex(streamID int64, substream string) (ei int64, err
 }
 
 //Insert the given DatapointArray into the sql database given the startindex of the array for the key.
+//The write is routed through this stream's Writer, so it can't race with a concurrent
+//Append/Insert/Delete targeting the same stream.
 func (s *SqlStore) Insert(streamID int64, substream string, startindex int64, da DatapointArray) error {
+	return s.InsertTTL(streamID, substream, startindex, da, 0)
+}
+
+//InsertTTL is Insert, but the inserted rows expire (and become invisible to GetByTime/GetByIndex,
+//and eligible for Reap to delete) after ttl has elapsed. A ttl <= 0 means the rows never expire.
+func (s *SqlStore) InsertTTL(streamID int64, substream string, startindex int64, da DatapointArray, ttl time.Duration) error {
+	return s.dispatch(streamID, writeRequest{op: opInsert, substream: substream, startindex: startindex, data: da, ttl: ttl})
+}
+
+//insertDirect performs the actual insert. It must only be called from the owning stream's Writer goroutine.
+//The insert and the streamendindex bump run in the same transaction, so GetEndIndex's view of the
+//stream's high-water mark is never out of sync with what was actually committed.
+func (s *SqlStore) insertDirect(streamID int64, substream string, startindex int64, da DatapointArray, ttl time.Duration) error {
 	dbytes, err := da.Encode(s.insertversion)
 	if err != nil {
 		return err
 	}
-	_, err = s.inserter.Exec(streamID, substream, da[len(da)-1].Timestamp, startindex+int64(len(da)),
-		s.insertversion, dbytes)
-	return err
+	endindex := startindex + int64(len(da))
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Stmt(s.inserter).Exec(streamID, substream, da[len(da)-1].Timestamp, endindex,
+		s.insertversion, dbytes, expiryValue(ttl), s.namespace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Stmt(s.bumpendindex).Exec(streamID, substream, s.namespace, endindex); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//expiryValue converts a TTL into the value bound to the Expiry column: nil (NULL, never expires)
+//when ttl <= 0, or the wall-clock time the row expires at.
+func expiryValue(ttl time.Duration) interface{} {
+	if ttl <= 0 {
+		return nil
+	}
+	return time.Now().Add(ttl)
+}
+
+//SetMaxBatchRows overrides DefaultMaxBatchRows for this store, controlling how many rows
+//WriteBatches groups into a single multi-row INSERT statement.
+func (s *SqlStore) SetMaxBatchRows(maxRows int) {
+	s.maxBatchRows = maxRows
 }
 
-//WriteBatches writes the given batch array
+//SetUseCopy enables or disables the Postgres-only COPY FROM STDIN fast path for WriteBatches.
+//It has no effect on non-Postgres stores.
+func (s *SqlStore) SetUseCopy(enabled bool) {
+	s.useCopy = enabled
+}
+
+//SetWriterFlushWindow overrides DefaultWriterFlushWindow for this store, controlling how long a
+//stream's Writer waits for more consecutive Appends before committing.
+func (s *SqlStore) SetWriterFlushWindow(d time.Duration) {
+	s.flushWindow = d
+}
+
+//streamSubstream identifies one (StreamId, Substream) pair, and is used to track the highest
+//EndIndex touched per pair when a single WriteBatches call spans several of them.
+type streamSubstream struct {
+	streamID  int64
+	substream string
+}
+
+//batchGroup is the batches within a single WriteBatches call that belong to one StreamId,
+//routed together through that stream's Writer.
+type batchGroup struct {
+	streamID int64
+	batches  []Batch
+}
+
+//groupBatchesByStream splits b into one batchGroup per distinct StreamId, preserving the order
+//each StreamId first appears in and the relative order of batches within it.
+func groupBatchesByStream(b []Batch) ([]batchGroup, error) {
+	index := make(map[int64]int, len(b))
+	var groups []batchGroup
+	for _, batch := range b {
+		streamID, err := batch.GetStreamID()
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[streamID]; ok {
+			groups[i].batches = append(groups[i].batches, batch)
+			continue
+		}
+		index[streamID] = len(groups)
+		groups = append(groups, batchGroup{streamID: streamID, batches: []Batch{batch}})
+	}
+	return groups, nil
+}
+
+//WriteBatches writes the given batch array. Batches are grouped by StreamId and each group is
+//routed through that stream's Writer, the same as Insert/Append, so a WriteBatches call can't
+//race with a concurrent Insert/Append/Delete targeting one of the same streams. Within a group,
+//consecutive batches are grouped into as few multi-row INSERT statements as possible (bounded by
+//maxBatchRows) instead of one Exec per batch, each group wrapped in its own transaction. On
+//Postgres, SetUseCopy(true) switches to a COPY FROM STDIN fast path instead.
 func (s *SqlStore) WriteBatches(b []Batch) error {
-	for i := 0; i < len(b); i++ {
-		streamID, err := b[i].GetStreamID()
+	groups, err := groupBatchesByStream(b)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := s.dispatch(g.streamID, writeRequest{op: opWriteBatches, batches: g.batches}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeBatchesDirect performs the actual write for one stream's batches, choosing the COPY fast
+//path or multi-row INSERT path. It must only be called from the owning stream's Writer goroutine.
+func (s *SqlStore) writeBatchesDirect(b []Batch) error {
+	if s.driver == DriverPostgres && s.useCopy {
+		return s.writeBatchesCopy(b)
+	}
+	return s.writeBatchesMultiInsert(b)
+}
+
+//writeBatchesMultiInsert groups consecutive batches into chunks of at most maxBatchRows rows,
+//each written as a single "INSERT ... VALUES (...),(...),..." statement inside its own
+//transaction.
+func (s *SqlStore) writeBatchesMultiInsert(b []Batch) error {
+	maxRows := s.maxBatchRows
+	if maxRows <= 0 {
+		maxRows = DefaultMaxBatchRows
+	}
+	for start := 0; start < len(b); start += maxRows {
+		end := start + maxRows
+		if end > len(b) {
+			end = len(b)
+		}
+		if err := s.insertBatchChunk(b[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//insertBatchChunk writes the given batches as a single multi-row INSERT wrapped in a transaction,
+//then bumps streamendindex once per distinct (StreamId, Substream) pair in the chunk, in the same
+//transaction, so GetEndIndex's high-water mark advances atomically with the rows it describes.
+//WriteBatches has no TTL of its own, so every row it writes has a NULL (never-expiring) Expiry;
+//use InsertTTL/AppendTTL for expiring rows.
+func (s *SqlStore) insertBatchChunk(b []Batch) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(b)*8)
+	rowPlaceholders := make([]string, len(b))
+	maxEndIndex := make(map[streamSubstream]int64, len(b))
+
+	for i, batch := range b {
+		streamID, err := batch.GetStreamID()
 		if err != nil {
 			return err
 		}
-		err = s.Insert(streamID, b[i].Substream, b[i].StartIndex, b[i].Data)
+		dbytes, err := batch.Data.Encode(s.insertversion)
 		if err != nil {
 			return err
 		}
+		endindex := batch.StartIndex + int64(len(batch.Data))
+		endtime := batch.Data[len(batch.Data)-1].Timestamp
+
+		row := [8]interface{}{streamID, batch.Substream, endtime, endindex, s.insertversion, dbytes, nil, s.namespace}
+		cols := make([]string, len(row))
+		for c, v := range row {
+			values = append(values, v)
+			cols[c] = s.placeholder(len(values))
+		}
+		rowPlaceholders[i] = "(" + strings.Join(cols, ",") + ")"
+
+		key := streamSubstream{streamID, batch.Substream}
+		if endindex > maxEndIndex[key] {
+			maxEndIndex[key] = endindex
+		}
 	}
-	return nil
+
+	query := "INSERT INTO datastream VALUES " + strings.Join(rowPlaceholders, ",") + ";"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, values...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	bumpendindex := tx.Stmt(s.bumpendindex)
+	for key, endindex := range maxEndIndex {
+		if _, err := bumpendindex.Exec(key.streamID, key.substream, s.namespace, endindex); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-//Append the given DatapointArray to the data stream for key
-func (s *SqlStore) Append(streamID int64, substream string, dp DatapointArray) error {
-	i, err := s.GetEndIndex(streamID, substream)
+//writeBatchesCopy bulk-loads the given batches via Postgres's COPY FROM STDIN (through
+//pq.CopyIn), which outperforms even a batched INSERT for large numbers of rows. Like
+//insertBatchChunk, it bumps streamendindex once per distinct (StreamId, Substream) pair in the
+//same transaction as the COPY, so the high-water mark tracks what was actually committed.
+func (s *SqlStore) writeBatchesCopy(b []Batch) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	return s.Insert(streamID, substream, i, dp)
+
+	stmt, err := tx.Prepare(pq.CopyIn("datastream", "streamid", "substream", "endtime", "endindex", "version", "data", "expiry", "namespace"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	maxEndIndex := make(map[streamSubstream]int64, len(b))
+
+	for i := 0; i < len(b); i++ {
+		streamID, err := b[i].GetStreamID()
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+		dbytes, err := b[i].Data.Encode(s.insertversion)
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+		endindex := b[i].StartIndex + int64(len(b[i].Data))
+		endtime := b[i].Data[len(b[i].Data)-1].Timestamp
+
+		if _, err := stmt.Exec(streamID, b[i].Substream, endtime, endindex, s.insertversion, dbytes, nil, s.namespace); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+
+		key := streamSubstream{streamID, b[i].Substream}
+		if endindex > maxEndIndex[key] {
+			maxEndIndex[key] = endindex
+		}
+	}
+
+	//A final, argument-less Exec flushes the buffered COPY data to the server.
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	bumpendindex := tx.Stmt(s.bumpendindex)
+	for key, endindex := range maxEndIndex {
+		if _, err := bumpendindex.Exec(key.streamID, key.substream, s.namespace, endindex); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//placeholder returns the driver-appropriate bind placeholder for the n-th ("1"-indexed)
+//parameter of a statement.
+func (s *SqlStore) placeholder(n int) string {
+	if s.driver == DriverPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+//Append the given DatapointArray to the data stream for key. The write is routed through this
+//stream's Writer: a GetEndIndex immediately followed by an Insert, with no locking in between,
+//would let two concurrent Append calls on the same (streamID, substream) compute the same start
+//index and violate the (StreamId, Substream, EndIndex) uniqueness constraint.
+func (s *SqlStore) Append(streamID int64, substream string, dp DatapointArray) error {
+	return s.AppendTTL(streamID, substream, dp, 0)
+}
+
+//AppendTTL is Append, but the appended rows expire after ttl has elapsed. A ttl <= 0 means the
+//rows never expire. See InsertTTL.
+func (s *SqlStore) AppendTTL(streamID int64, substream string, dp DatapointArray, ttl time.Duration) error {
+	return s.dispatch(streamID, writeRequest{op: opAppend, substream: substream, data: dp, ttl: ttl})
 }
 
 //DeleteStream deletes all data associated with the given stream in the database
 func (s *SqlStore) DeleteStream(streamID int64) error {
-	_, err := s.delstream.Exec(streamID)
-	return err
+	return s.dispatch(streamID, writeRequest{op: opDeleteStream})
+}
+
+//deleteStreamDirect performs the actual delete. It must only be called from the owning stream's
+//Writer goroutine. The datastream rows and their streamendindex rows are deleted in the same
+//transaction, so GetEndIndex can't keep reporting a high-water mark for data that no longer
+//exists.
+func (s *SqlStore) deleteStreamDirect(streamID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Stmt(s.delstream).Exec(streamID, s.namespace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Stmt(s.delstreamendindex).Exec(streamID, s.namespace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 //DeleteSubstream deletes all data associated with the given substream in the database
 func (s *SqlStore) DeleteSubstream(streamID int64, substream string) error {
-	_, err := s.delsubstream.Exec(streamID, substream)
-	return err
+	return s.dispatch(streamID, writeRequest{op: opDeleteSubstream, substream: substream})
+}
+
+//deleteSubstreamDirect performs the actual delete. It must only be called from the owning
+//stream's Writer goroutine. The datastream rows and their streamendindex row are deleted in the
+//same transaction, so GetEndIndex can't keep reporting a high-water mark for data that no longer
+//exists.
+func (s *SqlStore) deleteSubstreamDirect(streamID int64, substream string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Stmt(s.delsubstream).Exec(streamID, substream, s.namespace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Stmt(s.delsubstreamendindex).Exec(streamID, substream, s.namespace); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 //GetByTime returns a DataRange of datapoints starting at the starttime
 func (s *SqlStore) GetByTime(streamID int64, substream string, starttime float64) (dr DataRange, startindex int64, err error) {
-	rows, err := s.timequery.Query(streamID, substream, starttime)
+	rows, err := s.timequery.Query(streamID, substream, s.namespace, starttime)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -223,7 +931,7 @@ func (s *SqlStore) GetByTime(streamID int64, substream string, starttime float64
 
 //GetByIndex returns a DataRange of datapoints starting at the nearest dataindex to the given startindex
 func (s *SqlStore) GetByIndex(streamID int64, substream string, startindex int64) (dr DataRange, dataindex int64, err error) {
-	rows, err := s.indexquery.Query(streamID, substream, startindex)
+	rows, err := s.indexquery.Query(streamID, substream, s.namespace, startindex)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -264,5 +972,106 @@ func (s *SqlStore) GetByIndex(streamID int64, substream string, startindex int64
 		da = da.IRange(da.Length()-int(fromend), da.Length())
 	}
 
+	return &SqlRange{rows, da}, endindex - int64(da.Length()), nil
+}
+
+//GetByTimeRange returns a DataRange built from at most limit stored datastream rows with EndTime
+//in (start, end], starting at the nearest datapoint to start. limit bounds the number of
+//underlying rows fetched, not the number of datapoints returned: each row can hold an arbitrarily
+//large DatapointArray (see WriteBatches/Append), so the datapoint count of the returned DataRange
+//can be smaller or considerably larger than limit depending on how the matching data happens to
+//be chunked. Callers doing fixed-size pagination (e.g. an HTTP handler) should budget for that
+//variance rather than assuming len(result) == limit. Unlike GetByTime, the upper bound and the
+//row limit are both pushed into the query, so a paginated reader doesn't pay the cost of decoding
+//and then discarding trailing rows it doesn't want.
+func (s *SqlStore) GetByTimeRange(streamID int64, substream string, start, end float64, limit int64) (dr DataRange, startindex int64, err error) {
+	rows, err := s.timerangequery.Query(streamID, substream, s.namespace, start, end, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !rows.Next() { //Check if there is any data to read
+		startindex, err = s.GetEndIndex(streamID, substream)
+		if rows.Err() != nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		return EmptyRange{}, startindex, err
+	}
+
+	//There is some data!
+	var version int
+	var endindex int64
+	var data []byte
+	if err = rows.Scan(&version, &endindex, &data); err != nil {
+		return EmptyRange{}, endindex, err
+	}
+
+	da, err := DecodeDatapointArray(data, version)
+	if err != nil {
+		rows.Close()
+		return EmptyRange{}, endindex, err
+	}
+	tmp := da.TStart(start)
+	da = &tmp
+	if da == nil || int64(da.Length()) > endindex {
+		rows.Close()
+		return EmptyRange{}, endindex, ErrorDatabaseCorrupted
+	}
+
+	return &SqlRange{rows, da}, endindex - int64(da.Length()), nil
+}
+
+//GetByIndexRange returns a DataRange built from at most limit stored datastream rows with index
+//in (start, end], starting at the nearest dataindex to start. limit bounds the number of
+//underlying rows fetched, not the number of datapoints returned: each row can hold an arbitrarily
+//large DatapointArray (see WriteBatches/Append), so the datapoint count of the returned DataRange
+//can be smaller or considerably larger than limit depending on how the matching data happens to
+//be chunked. Callers doing fixed-size pagination (e.g. an HTTP handler) should budget for that
+//variance rather than assuming len(result) == limit. Unlike GetByIndex, the upper bound and the
+//row limit are both pushed into the query, so a paginated reader doesn't pay the cost of decoding
+//and then discarding trailing rows it doesn't want.
+func (s *SqlStore) GetByIndexRange(streamID int64, substream string, start, end, limit int64) (dr DataRange, dataindex int64, err error) {
+	rows, err := s.indexrangequery.Query(streamID, substream, s.namespace, start, end, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !rows.Next() { //Check if there is any data to read
+		start, err = s.GetEndIndex(streamID, substream)
+		if rows.Err() != nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		return EmptyRange{}, start, err
+	}
+
+	//There is some data!
+	var version int
+	var endindex int64
+	var data []byte
+	if err = rows.Scan(&version, &endindex, &data); err != nil {
+		return EmptyRange{}, endindex, err
+	}
+
+	da, err := DecodeDatapointArray(data, version)
+	if err != nil {
+		rows.Close()
+		return EmptyRange{}, endindex, err
+	}
+
+	if da == nil || int64(da.Length()) > endindex {
+		rows.Close()
+		return EmptyRange{}, endindex, ErrorDatabaseCorrupted
+	}
+
+	//Lastly, we start the DatapointArray from the correct index
+	//This subtraction is guaranteed to work, since query requires $gt
+	fromend := endindex - start
+	if fromend < int64(da.Length()) {
+		//The index we want is within the datarange
+		da = da.IRange(da.Length()-int(fromend), da.Length())
+	}
+
 	return &SqlRange{rows, da}, endindex - int64(da.Length()), nil
 }
\ No newline at end of file
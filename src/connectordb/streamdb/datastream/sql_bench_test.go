@@ -0,0 +1,89 @@
+package datastream
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//newBenchStore opens a fresh in-memory SQLite-backed SqlStore for benchmarking. Each call gets
+//its own database, so benchmarks don't interfere with each other.
+func newBenchStore(b *testing.B) *SqlStore {
+	b.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		b.Fatal(err)
+	}
+
+	s, err := OpenSQLiteStore(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(s.Close)
+
+	return s
+}
+
+//syntheticBatches builds nStreams substreams' worth of batches, batchesPerStream batches each,
+//pointsPerBatch datapoints per batch - a synthetic workload spread across many substreams, the
+//shape WriteBatches is meant to optimize for.
+func syntheticBatches(nStreams, batchesPerStream, pointsPerBatch int) []Batch {
+	batches := make([]Batch, 0, nStreams*batchesPerStream)
+	for stream := 0; stream < nStreams; stream++ {
+		substream := fmt.Sprintf("substream%d", stream)
+		startindex := int64(0)
+		for j := 0; j < batchesPerStream; j++ {
+			da := make(DatapointArray, pointsPerBatch)
+			for k := range da {
+				da[k] = Datapoint{Timestamp: float64(startindex) + float64(k), Data: float64(k)}
+			}
+			batches = append(batches, Batch{
+				StreamId:   int64(stream + 1),
+				Substream:  substream,
+				StartIndex: startindex,
+				Data:       da,
+			})
+			startindex += int64(pointsPerBatch)
+		}
+	}
+	return batches
+}
+
+//BenchmarkWriteBatches compares writing a synthetic multi-substream workload one row at a time
+//(through Insert, the way a caller without WriteBatches would have to) against writing it with
+//WriteBatches, which groups rows into multi-row INSERT statements.
+func BenchmarkWriteBatches(b *testing.B) {
+	const nStreams = 8
+	const batchesPerStream = 50
+	const pointsPerBatch = 10
+
+	b.Run("SingleRow", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s := newBenchStore(b)
+			for _, batch := range syntheticBatches(nStreams, batchesPerStream, pointsPerBatch) {
+				if err := s.Insert(batch.StreamId, batch.Substream, batch.StartIndex, batch.Data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			s.Close()
+		}
+	})
+
+	b.Run("Batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s := newBenchStore(b)
+			if err := s.WriteBatches(syntheticBatches(nStreams, batchesPerStream, pointsPerBatch)); err != nil {
+				b.Fatal(err)
+			}
+			s.Close()
+		}
+	})
+}
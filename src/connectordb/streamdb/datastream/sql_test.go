@@ -0,0 +1,240 @@
+package datastream
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//newTestStore opens a fresh in-memory SQLite-backed SqlStore for a single test. Each call gets
+//its own database, so tests can't interfere with each other.
+func newTestStore(t *testing.T) *SqlStore {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func datapoints(startTime float64, n int) DatapointArray {
+	da := make(DatapointArray, n)
+	for i := range da {
+		da[i] = Datapoint{Timestamp: startTime + float64(i), Data: float64(i)}
+	}
+	return da
+}
+
+func rowCount(t *testing.T, s *SqlStore, streamID int64, substream string) int {
+	t.Helper()
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM datastream WHERE StreamId=? AND Substream=? AND Namespace=?",
+		streamID, substream, s.namespace).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+//TestConcurrentWritesSerializeThroughWriter appends from many goroutines to the same stream and
+//substream concurrently, and checks that every Append's worth of points landed exactly once: if
+//the per-stream Writer failed to serialize them, two Appends could race on the same EndIndex and
+//violate the table's (StreamId, Substream, EndIndex) uniqueness constraint, or silently drop one.
+func TestConcurrentWritesSerializeThroughWriter(t *testing.T) {
+	s := newTestStore(t)
+
+	const streamID = 1
+	const substream = "sub"
+	const goroutines = 8
+	const pointsPerAppend = 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			if err := s.Append(streamID, substream, datapoints(float64(g*pointsPerAppend), pointsPerAppend)); err != nil {
+				errs <- err
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent Append failed: %v", err)
+	}
+
+	ei, err := s.GetEndIndex(streamID, substream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(goroutines * pointsPerAppend); ei != want {
+		t.Errorf("GetEndIndex = %d, want %d (a lost or duplicated Append would show up here)", ei, want)
+	}
+	if n := rowCount(t, s, streamID, substream); n != goroutines {
+		t.Errorf("got %d datastream rows, want %d (one per Append)", n, goroutines)
+	}
+}
+
+//TestReapDoesNotResurrectIndices checks that Reap deleting expired rows can't make GetEndIndex
+//fall back to an earlier index: once index N has been handed out, it must never be handed out
+//again, even after the row it was assigned to has expired and been reaped.
+func TestReapDoesNotResurrectIndices(t *testing.T) {
+	s := newTestStore(t)
+
+	const streamID = 1
+	const substream = "sub"
+
+	if err := s.InsertTTL(streamID, substream, 0, datapoints(0, 3), time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) //ensure the row's Expiry is now in the past
+
+	//Run the reap query directly instead of through Reap's background ticker, so the test is
+	//deterministic rather than timing-dependent.
+	if _, err := s.reaper.Exec(s.namespace); err != nil {
+		t.Fatal(err)
+	}
+	if n := rowCount(t, s, streamID, substream); n != 0 {
+		t.Fatalf("expected the expired row to be reaped, got %d rows left", n)
+	}
+
+	ei, err := s.GetEndIndex(streamID, substream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei != 3 {
+		t.Fatalf("GetEndIndex = %d after reaping an expired row, want 3 (streamendindex must not fall back to the rows still present)", ei)
+	}
+
+	if err := s.Append(streamID, substream, datapoints(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	ei, err = s.GetEndIndex(streamID, substream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei != 5 {
+		t.Fatalf("GetEndIndex = %d after appending past a reaped index, want 5 (the reaped indices 0-2 must not be reused)", ei)
+	}
+}
+
+//TestDeleteResetsEndIndex checks that DeleteStream/DeleteSubstream/DeleteNamespace each reset
+//GetEndIndex back to 0, so a fresh write after a delete starts from scratch instead of skipping
+//ahead to cover indices that no longer describe any data.
+func TestDeleteResetsEndIndex(t *testing.T) {
+	const substream = "sub"
+
+	cases := []struct {
+		name   string
+		delete func(s *SqlStore, streamID int64) error
+	}{
+		{"DeleteStream", func(s *SqlStore, streamID int64) error { return s.DeleteStream(streamID) }},
+		{"DeleteSubstream", func(s *SqlStore, streamID int64) error { return s.DeleteSubstream(streamID, substream) }},
+		{"DeleteNamespace", func(s *SqlStore, streamID int64) error { return s.DeleteNamespace(s.namespace) }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestStore(t)
+			const streamID = 1
+
+			if err := s.Insert(streamID, substream, 0, datapoints(0, 4)); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.delete(s, streamID); err != nil {
+				t.Fatal(err)
+			}
+
+			ei, err := s.GetEndIndex(streamID, substream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ei != 0 {
+				t.Fatalf("GetEndIndex = %d after %s, want 0 (streamendindex must be cleared along with the deleted rows)", ei, c.name)
+			}
+
+			if err := s.Insert(streamID, substream, 0, datapoints(0, 2)); err != nil {
+				t.Fatal(err)
+			}
+			ei, err = s.GetEndIndex(streamID, substream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ei != 2 {
+				t.Fatalf("GetEndIndex = %d after a fresh Insert post-%s, want 2", ei, c.name)
+			}
+		})
+	}
+}
+
+//TestDeleteNamespaceIsolation checks that DeleteNamespace only affects the namespace it's given,
+//leaving a differently-namespaced store's data (including its streamendindex high-water mark)
+//untouched - the whole point of giving each tenant its own namespace.
+func TestDeleteNamespaceIsolation(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	tenantA, err := OpenSQLiteStoreNamespaced(db, "tenantA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenantA.Close()
+	tenantB, err := OpenSQLiteStoreNamespaced(db, "tenantB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenantB.Close()
+
+	const streamID = 1
+	const substream = "sub"
+	if err := tenantA.Insert(streamID, substream, 0, datapoints(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.Insert(streamID, substream, 0, datapoints(0, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tenantA.DeleteNamespace("tenantA"); err != nil {
+		t.Fatal(err)
+	}
+
+	ei, err := tenantA.GetEndIndex(streamID, substream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei != 0 {
+		t.Fatalf("tenantA GetEndIndex = %d after DeleteNamespace, want 0", ei)
+	}
+
+	ei, err = tenantB.GetEndIndex(streamID, substream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei != 5 {
+		t.Fatalf("tenantB GetEndIndex = %d after tenantA's DeleteNamespace, want 5 (tenants must stay isolated)", ei)
+	}
+}
@@ -0,0 +1,232 @@
+package datastream
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+//DefaultWriterFlushWindow is how long a stream's Writer waits after receiving an Append before
+//committing, in case more Appends for the same substream arrive in the meantime and can be
+//folded into the same transaction.
+const DefaultWriterFlushWindow = 10 * time.Millisecond
+
+//writeOp identifies which SqlStore mutation a writeRequest asks the owning stream's Writer to perform.
+type writeOp int
+
+const (
+	opInsert writeOp = iota
+	opAppend
+	opDeleteSubstream
+	opDeleteStream
+	opWriteBatches
+)
+
+//writeRequest is a single mutation queued on a stream's Writer. The caller blocks on reply.
+type writeRequest struct {
+	op         writeOp
+	substream  string
+	startindex int64
+	data       DatapointArray
+	batches    []Batch
+	ttl        time.Duration
+	reply      chan error
+}
+
+//streamWriter is the "Writer" for a single stream: one goroutine owns every mutation
+//(Insert/Append/WriteBatches/DeleteSubstream/DeleteStream) for the stream's StreamId, so that two
+//concurrent writers can never race on the same (StreamId, Substream, EndIndex) uniqueness
+//constraint.
+type streamWriter struct {
+	streamID int64
+	store    *SqlStore
+	requests chan writeRequest
+
+	//mu guards closed, and is held across the closed-check-and-send in dispatch and the
+	//closed-set-and-close in SqlStore.Close, so a dispatch can never send on a channel that
+	//Close has already closed. It's scoped to this one streamWriter (not the store-wide
+	//writersMu) so that closing the store doesn't serialize dispatches across other streams.
+	mu     sync.Mutex
+	closed bool
+}
+
+//dispatch sends a request to the Writer owning streamID and blocks for its reply. It fails with
+//ErrStoreClosed instead of sending if the store has been closed.
+func (s *SqlStore) dispatch(streamID int64, req writeRequest) error {
+	w, err := s.getWriter(streamID)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrStoreClosed
+	}
+	req.reply = make(chan error, 1)
+	w.requests <- req
+	w.mu.Unlock()
+
+	return <-req.reply
+}
+
+//getWriter returns the Writer goroutine for streamID, spawning one on first use, or
+//ErrStoreClosed if the store has already been closed.
+func (s *SqlStore) getWriter(streamID int64) (*streamWriter, error) {
+	s.writersMu.Lock()
+	defer s.writersMu.Unlock()
+
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	w, ok := s.writers[streamID]
+	if !ok {
+		w = &streamWriter{
+			streamID: streamID,
+			store:    s,
+			requests: make(chan writeRequest),
+		}
+		s.writers[streamID] = w
+		go w.run()
+	}
+	return w, nil
+}
+
+//run is the Writer's goroutine body: it serializes every mutation for streamID, batching
+//consecutive Appends to the same substream that arrive within the store's flush window into a
+//single transaction.
+func (w *streamWriter) run() {
+	for req := range w.requests {
+		if req.op != opAppend {
+			req.reply <- w.execOne(req)
+			continue
+		}
+		w.runAppendBatch(req)
+	}
+}
+
+//execOne performs a single non-Append mutation directly against the database.
+func (w *streamWriter) execOne(req writeRequest) error {
+	switch req.op {
+	case opInsert:
+		return w.store.insertDirect(w.streamID, req.substream, req.startindex, req.data, req.ttl)
+	case opDeleteSubstream:
+		return w.store.deleteSubstreamDirect(w.streamID, req.substream)
+	case opDeleteStream:
+		return w.store.deleteStreamDirect(w.streamID)
+	case opWriteBatches:
+		return w.store.writeBatchesDirect(req.batches)
+	}
+	return ErrWTF
+}
+
+//runAppendBatch collects consecutive Append requests for the same substream that arrive within
+//the store's flush window, then commits them together as a single transaction. Any request that
+//doesn't qualify (a different op, or a different substream) ends the collection early and is
+//processed on its own once the batch is flushed.
+func (w *streamWriter) runAppendBatch(first writeRequest) {
+	batch := []writeRequest{first}
+	timer := time.NewTimer(w.store.flushWindow)
+	defer timer.Stop()
+
+	for {
+		select {
+		case req, ok := <-w.requests:
+			if !ok {
+				w.commitAppends(first.substream, batch)
+				return
+			}
+			if req.op != opAppend || req.substream != first.substream {
+				w.commitAppends(first.substream, batch)
+				if req.op == opAppend {
+					w.commitAppends(req.substream, []writeRequest{req})
+				} else {
+					req.reply <- w.execOne(req)
+				}
+				return
+			}
+			batch = append(batch, req)
+		case <-timer.C:
+			w.commitAppends(first.substream, batch)
+			return
+		}
+	}
+}
+
+//commitAppends writes a batch of same-substream Appends as one transaction: the end index is
+//read once, and each Append's start index is derived from running that index forward in
+//memory, instead of each Append re-reading it and racing with the others.
+func (w *streamWriter) commitAppends(substream string, batch []writeRequest) {
+	s := w.store
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		replyAll(batch, err)
+		return
+	}
+
+	endindex, err := s.getEndIndexTx(tx, w.streamID, substream)
+	if err != nil {
+		tx.Rollback()
+		replyAll(batch, err)
+		return
+	}
+
+	startindex := endindex
+	txInserter := tx.Stmt(s.inserter)
+	errs := make([]error, len(batch))
+	for i, req := range batch {
+		dbytes, err := req.data.Encode(s.insertversion)
+		if err == nil {
+			endindex += int64(len(req.data))
+			_, err = txInserter.Exec(w.streamID, substream, req.data[len(req.data)-1].Timestamp,
+				endindex, s.insertversion, dbytes, expiryValue(req.ttl), s.namespace)
+		}
+		errs[i] = err
+	}
+
+	if endindex != startindex {
+		if _, err := tx.Stmt(s.bumpendindex).Exec(w.streamID, substream, s.namespace, endindex); err != nil {
+			tx.Rollback()
+			replyAll(batch, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+
+	for i, req := range batch {
+		req.reply <- errs[i]
+	}
+}
+
+//replyAll sends the same error to every request in a batch.
+func replyAll(batch []writeRequest, err error) {
+	for _, req := range batch {
+		req.reply <- err
+	}
+}
+
+//getEndIndexTx is GetEndIndex run inside an existing transaction, so a batch of Appends can read
+//the end index and insert past it without another writer interleaving in between.
+func (s *SqlStore) getEndIndexTx(tx *sql.Tx, streamID int64, substream string) (int64, error) {
+	rows, err := tx.Stmt(s.endindex).Query(streamID, substream, s.namespace)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, ErrWTF
+	}
+	var ei int64
+	err = rows.Scan(&ei)
+	return ei, err
+}
@@ -12,17 +12,37 @@ All Rights Reserved
 
 import (
 	"config"
+	"fmt"
 	"os"
 	"os/exec"
 )
 
+//sqlShellCommand gives the client binary and arguments to open an interactive shell against the
+//configured SQL backend, so the command isn't hard-wired to psql.
+func sqlShellCommand(connectionString string) (string, []string, error) {
+	switch config.Get().Sql.Type {
+	case "", "postgres", "postgresql":
+		return "psql", []string{connectionString}, nil
+	case "sqlite", "sqlite3":
+		return "sqlite3", []string{connectionString}, nil
+	case "mysql":
+		return "mysql", []string{connectionString}, nil
+	}
+	return "", nil, fmt.Errorf("Unrecognized SQL backend '%s'", config.Get().Sql.Type)
+}
+
 func init() {
 	help := "Runs an interactive database shell"
 	usage := `Usage: sql`
 	name := "sql"
 
 	main := func(shell *Shell, args []string) uint8 {
-		cmd := exec.Command("psql", config.Get().Sql.GetSqlConnectionString())
+		bin, binargs, err := sqlShellCommand(config.Get().Sql.GetSqlConnectionString())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		cmd := exec.Command(bin, binargs...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		cmd.Stdin = os.Stdin